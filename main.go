@@ -9,12 +9,10 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
-	"unicode/utf8"
 
-	"github.com/go-git/go-git/plumbing/format/gitignore"
-	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/encoding/unicode"
+	"github.com/msageha/prompt_generator/pkg/budget"
+	"github.com/msageha/prompt_generator/pkg/collector"
+	"github.com/msageha/prompt_generator/pkg/prompt"
 )
 
 type extensionsFlag []string
@@ -35,6 +33,24 @@ func (e *extensionsFlag) Set(value string) error {
 	return nil
 }
 
+// stringListFlag はカンマ区切りまたは複数回指定で値を積み上げる汎用フラグです。
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	parts := strings.Split(value, ",")
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			*s = append(*s, p)
+		}
+	}
+	return nil
+}
+
 func printHelp() {
 	fmt.Println("Usage options:")
 	flag.PrintDefaults()
@@ -45,233 +61,165 @@ func exitWithError(message string) {
 	os.Exit(1)
 }
 
-// getEncodingByName returns an encoding.Encoding by name
-func getEncodingByName(name string) (encoding.Encoding, error) {
-	switch strings.ToLower(name) {
-	case "shift-jis", "shiftjis", "sjis":
-		return japanese.ShiftJIS, nil
-	case "euc-jp", "eucjp":
-		return japanese.EUCJP, nil
-	case "iso-2022-jp", "iso2022jp":
-		return japanese.ISO2022JP, nil
-	case "utf-16le":
-		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
-	case "utf-16be":
-		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
-	case "utf-8", "utf8":
-		return unicode.UTF8, nil
-	default:
-		return nil, fmt.Errorf("サポートされていないエンコーディング: %s", name)
-	}
-}
+func main() {
+	var exts extensionsFlag
+	flag.Var(&exts, "e", "対象の拡張子（例：-e .py -e .go あるいは -e .py,.go）")
+	inputPath := flag.String("p", "./", "入力ディレクトリのパス (絶対パスまたは相対パス)")
+	encodingName := flag.String("encoding", "", "入力ファイルのエンコーディング（例：shift-jis, euc-jp, iso-2022-jp）。指定がなければ自動検出を試みます。")
+	verbose := flag.Bool("verbose", false, "検出したエンコーディングと信頼度を標準エラー出力に表示")
+	includeBinary := flag.Bool("include-binary", false, "バイナリと判定されたファイルも除外せずに含める")
+	var allowedContentTypes stringListFlag
+	flag.Var(&allowedContentTypes, "allowed-content-types", "テキストとして扱う MIME タイプ（例：-allowed-content-types text/*,application/json）")
+	var extraIgnoreFiles stringListFlag
+	flag.Var(&extraIgnoreFiles, "ignore-file", "追加で読み込む無視ファイル名（例：-ignore-file .dockerignore,.npmignore）")
+	maxTokens := flag.Int("max-tokens", 0, "プロンプトのトークン数の上限（概算）。0 以下なら無制限")
+	tokenizerName := flag.String("tokenizer", "heuristic", "トークン数の見積もり方法（現状 \"heuristic\" のみ対応）")
+	truncateStrategyName := flag.String("truncate-strategy", string(budget.StrategyTruncate), "トークン予算を超えた場合の縮小方法（truncate, head-tail, skip-largest, summarize）")
+	formatName := flag.String("format", string(prompt.FormatPlain), "出力形式（plain, markdown, xml, json）")
+	templatePath := flag.String("template", "", "出力形式の代わりに使用する text/template ファイルのパス（.Files, .Instructions を参照可能）")
+	showHelp := flag.Bool("h", false, "ヘルプメッセージを表示")
+	flag.Parse()
 
-// detectAndConvertEncoding attempts to detect the encoding of the given data and convert it to UTF-8
-func detectAndConvertEncoding(data []byte, encodingName string) (string, error) {
-	// If encoding is specified, use that
-	if encodingName != "" {
-		enc, err := getEncodingByName(encodingName)
-		if err != nil {
-			return "", fmt.Errorf("指定されたエンコーディング '%s' が見つかりません: %v", encodingName, err)
-		}
-		decoder := enc.NewDecoder()
-		result, err := decoder.Bytes(data)
-		if err != nil {
-			return "", fmt.Errorf("指定されたエンコーディング '%s' でデコードできませんでした: %v", encodingName, err)
-		}
-		return string(result), nil
+	if *showHelp {
+		printHelp()
+		return
 	}
 
-	// First check if it's already valid UTF-8
-	if utf8.Valid(data) {
-		return string(data), nil
+	if *tokenizerName != "heuristic" {
+		exitWithError(fmt.Sprintf("サポートされていないトークナイザです: %s", *tokenizerName))
 	}
-
-	// Try common encodings
-	encodings := []encoding.Encoding{
-		japanese.ShiftJIS,
-		japanese.EUCJP,
-		japanese.ISO2022JP,
-		unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
-		unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	truncateStrategy := budget.Strategy(*truncateStrategyName)
+	if !slices.Contains(budget.ValidStrategies, truncateStrategy) {
+		exitWithError(fmt.Sprintf("サポートされていない truncate-strategy です: %s", *truncateStrategyName))
+	}
+	format := prompt.Format(*formatName)
+	if *templatePath == "" && !slices.Contains(prompt.ValidFormats, format) {
+		exitWithError(fmt.Sprintf("サポートされていない出力形式です: %s", *formatName))
 	}
 
-	for _, enc := range encodings {
-		decoder := enc.NewDecoder()
-		result, err := decoder.Bytes(data)
-		if err == nil && utf8.Valid(result) {
-			return string(result), nil
-		}
+	// デフォルト拡張子を設定 (.py)。ただし -e . と指定された場合は全ファイル対象。
+	if len(exts) == 0 {
+		exts = []string{".py"}
 	}
 
-	// If we can't determine the encoding, just return as is with a warning
-	fmt.Fprintf(os.Stderr, "警告: ファイルのエンコーディングを検出できませんでした。UTF-8として処理します。\n")
-	return string(data), nil
-}
+	absInputPath, err := filepath.Abs(*inputPath)
+	if err != nil {
+		exitWithError(fmt.Sprintf("入力パスの解析に失敗しました: %v", err))
+	}
 
-// ディレクトリ内のテキストファイルの内容を収集
-func collectFilesContent(absInputPath string, targetExtensions []string, matcher gitignore.Matcher, encodingName string) (map[string]string, error) {
-	filesContent := make(map[string]string)
+	// .gitignore階層、.git/info/exclude、グローバルな core.excludesFile などを読み込み
+	matcher, err := collector.LoadIgnoreMatcher(absInputPath, extraIgnoreFiles)
+	if err != nil {
+		exitWithError(fmt.Sprintf(".gitignoreの読み込みに失敗しました: %v", err))
+	}
 
-	err := filepath.Walk(absInputPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "パスへのアクセスエラー: %v\n", err)
-			return nil
-		}
+	opts := collector.Options{
+		Extensions:          exts,
+		Matcher:             matcher,
+		EncodingName:        *encodingName,
+		Verbose:             *verbose,
+		IncludeBinary:       *includeBinary,
+		AllowedContentTypes: allowedContentTypes,
+	}
 
-		// 相対パスを取得して matcher で判定
-		relPath, err := filepath.Rel(absInputPath, path)
+	// トークン予算が指定されていれば、内容を読まずにファイルサイズだけを集めて縮小計画を立てる
+	var plan *budget.Plan
+	if *maxTokens > 0 {
+		candidates, err := collector.ListCandidatePaths(absInputPath, opts)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "相対パス取得エラー: %v\n", err)
-			return nil
+			exitWithError(fmt.Sprintf("対象ファイルの一覧取得に失敗しました: %v", err))
 		}
-
-		// 隠しディレクトリ or 隠しファイルは無視（ただし .gitignore は例外）
-		if strings.HasPrefix(info.Name(), ".") {
-			// ディレクトリを無視する場合は SkipDir を返す
-			if info.IsDir() {
-				return filepath.SkipDir
+		allowedContentTypes := opts.ResolvedAllowedContentTypes()
+		fileSizes := make([]budget.FileSize, 0, len(candidates))
+		for _, path := range candidates {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
 			}
-			// ファイルの場合も .gitignore 以外は無視
-			if info.Name() != ".gitignore" {
-				return nil
+			// バイナリファイルは Collect でも除外されるため、縮小計画の見積もりに
+			// 含めてしまうと予算が不必要に厳しくなる。内容全体は読まずに先頭だけ確認する。
+			if !*includeBinary {
+				if binary, err := collector.SniffIsBinary(path, allowedContentTypes); err == nil && binary {
+					continue
+				}
 			}
+			fileSizes = append(fileSizes, budget.FileSize{Path: path, Size: info.Size()})
 		}
+		plan = budget.NewPlan(fileSizes, *maxTokens, truncateStrategy, budget.HeuristicTokenizer{})
+	}
 
-		// matcher で無視判定
-		// (隠しファイル/ディレクトリは上記で既にスキップ済み)
-		if matcher != nil && matcher.Match(strings.Split(relPath, string(os.PathSeparator)), info.IsDir()) {
-			// ディレクトリなら以降探索をスキップ
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			// ファイルなら無視
-			return nil
-		}
+	usingTemplate := *templatePath != ""
 
-		// ディレクトリは継続
-		if info.IsDir() {
-			return nil
+	var pw prompt.Writer
+	if !usingTemplate {
+		pw, err = prompt.NewWriter(format, os.Stdout)
+		if err != nil {
+			exitWithError(err.Error())
 		}
+	}
 
-		// もし拡張子リストに "." が含まれていたら、すべてのファイルを対象
-		// 含まれていなければ、通常通り拡張子チェックを行う
-		if !slices.Contains(targetExtensions, ".") {
-			if !slices.Contains(targetExtensions, filepath.Ext(path)) {
+	fileCount := 0
+	totalTokens := 0
+	headerWritten := false
+	var affectedFiles []string
+	var templateFiles []prompt.File
+	err = collector.Collect(absInputPath, opts, func(path, content, detectedEncoding string) error {
+		if plan != nil {
+			adjusted, affected, skip := plan.Apply(path, content)
+			if skip {
+				affectedFiles = append(affectedFiles, fmt.Sprintf("%s (除外)", path))
 				return nil
 			}
+			if affected {
+				affectedFiles = append(affectedFiles, path)
+			}
+			content = adjusted
 		}
 
-		// ファイル内容を読み取る
-		data, err := os.ReadFile(path)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ファイル読み取りエラー: %v\n", err)
-			return nil
-		}
+		fileCount++
+		totalTokens += budget.HeuristicTokenizer{}.CountTokens(content)
 
-		// エンコーディング検出と変換
-		content, err := detectAndConvertEncoding(data, encodingName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "エンコーディング変換エラー (%s): %v\n", path, err)
+		file := prompt.File{Path: path, Content: content, DetectedEncoding: detectedEncoding}
+		if usingTemplate {
+			templateFiles = append(templateFiles, file)
 			return nil
 		}
-		filesContent[path] = content
-
-		return nil
+		// ヘッダーは最初のファイルが確定するまで書き出さない。対象ファイルが
+		// 1 つもない場合に標準出力へ中途半端なヘッダーだけが残るのを防ぐため。
+		if !headerWritten {
+			if err := pw.WriteHeader(); err != nil {
+				return err
+			}
+			headerWritten = true
+		}
+		return pw.WriteFile(file)
 	})
-
-	return filesContent, err
-}
-
-// createPrompt はリポジトリのファイル内容と指示文を組み合わせたプロンプトを生成します。
-func createPrompt(filesContent map[string]string, instructions string) string {
-	var promptBuilder strings.Builder
-
-	promptBuilder.WriteString("以下は対象リポジトリのすべてのファイル内容です。\n")
-	promptBuilder.WriteString("これらを参考に、後述の指示に従ってリポジトリを変更してください。\n\n")
-
-	for path, content := range filesContent {
-		promptBuilder.WriteString(fmt.Sprintf("----------\n[File]: %s\n[Content Start]\n", path))
-		promptBuilder.WriteString(content)
-		promptBuilder.WriteString("\n[Content End]\n\n")
-	}
-
-	promptBuilder.WriteString("----------\n以下が指示文です:\n")
-	promptBuilder.WriteString(instructions)
-
-	return promptBuilder.String()
-}
-
-func loadGitignorePatterns(gitignorePath string) (gitignore.Matcher, error) {
-	// .gitignoreがない場合はnilを返す
-	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-
-	file, err := os.Open(gitignorePath)
 	if err != nil {
-		return nil, err
+		exitWithError(fmt.Sprintf("ファイル内容の収集中にエラーが発生しました: %v", err))
 	}
-	defer file.Close()
 
-	ps := make([]gitignore.Pattern, 0)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// 空行やコメント行はスキップ
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
-			continue
+	// トークン予算の集計は、対象ファイルが 0 件だった場合の分岐より先に行う。
+	// そうしないと「予算超過で全ファイルが除外された」場合でも "有効なファイルが
+	// 見つかりませんでした" という、ファイルが最初から一致しなかったかのような
+	// 誤解を招くメッセージだけが表示されてしまう。
+	if plan != nil {
+		fmt.Fprintf(os.Stderr, "プロンプトの合計トークン数(概算): %d\n", totalTokens)
+		if len(affectedFiles) > 0 {
+			fmt.Fprintf(os.Stderr, "トークン予算(%s戦略)により内容を調整したファイル:\n", truncateStrategy)
+			for _, path := range affectedFiles {
+				fmt.Fprintf(os.Stderr, "  %s\n", path)
+			}
 		}
-		ps = append(ps, gitignore.ParsePattern(line, nil))
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return gitignore.NewMatcher(ps), nil
-}
-
-func main() {
-	var exts extensionsFlag
-	flag.Var(&exts, "e", "対象の拡張子（例：-e .py -e .go あるいは -e .py,.go）")
-	inputPath := flag.String("p", "./", "入力ディレクトリのパス (絶対パスまたは相対パス)")
-	encodingName := flag.String("encoding", "", "入力ファイルのエンコーディング（例：shift-jis, euc-jp, iso-2022-jp）。指定がなければ自動検出を試みます。")
-	showHelp := flag.Bool("h", false, "ヘルプメッセージを表示")
-	flag.Parse()
-
-	if *showHelp {
-		printHelp()
-		return
-	}
-
-	// デフォルト拡張子を設定 (.py)。ただし -e . と指定された場合は全ファイル対象。
-	if len(exts) == 0 {
-		exts = []string{".py"}
-	}
-
-	absInputPath, err := filepath.Abs(*inputPath)
-	if err != nil {
-		exitWithError(fmt.Sprintf("入力パスの解析に失敗しました: %v", err))
 	}
 
-	// .gitignoreを読み込み
-	matcher, err := loadGitignorePatterns(filepath.Join(absInputPath, ".gitignore"))
-	if err != nil {
-		exitWithError(fmt.Sprintf(".gitignoreの読み込みに失敗しました: %v", err))
-	}
-
-	filesContent, err := collectFilesContent(absInputPath, exts, matcher, *encodingName)
-	if err != nil {
-		exitWithError(fmt.Sprintf("ファイル内容の収集中にエラーが発生しました: %v", err))
-	}
-
-	if len(filesContent) == 0 {
+	if fileCount == 0 {
+		if plan != nil && len(affectedFiles) > 0 {
+			exitWithError("トークン予算内に収まるファイルがありませんでした（すべて除外されました）")
+		}
 		exitWithError("有効なファイルが見つかりませんでした")
 	}
 
-	fmt.Println("変更の指示文を入力してください（Ctrl+Dで終了）:")
+	fmt.Fprintln(os.Stderr, "変更の指示文を入力してください（Ctrl+Dで終了）:")
 	scanner := bufio.NewScanner(os.Stdin)
 	var instructions bytes.Buffer
 	for scanner.Scan() {
@@ -284,6 +232,16 @@ func main() {
 		os.Exit(1)
 	}
 
-	finalPrompt := createPrompt(filesContent, instructions.String())
-	fmt.Println(finalPrompt)
-}
\ No newline at end of file
+	if usingTemplate {
+		doc := prompt.Document{Files: templateFiles, Instructions: instructions.String()}
+		if err := prompt.RenderTemplate(os.Stdout, *templatePath, doc); err != nil {
+			exitWithError(fmt.Sprintf("テンプレートの描画に失敗しました: %v", err))
+		}
+		return
+	}
+
+	if err := pw.WriteInstructions(instructions.String()); err != nil {
+		exitWithError(fmt.Sprintf("プロンプトの書き込みに失敗しました: %v", err))
+	}
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain builds the CLI binary once up front so individual tests can drive it
+// as a black box (flag parsing, stdout/stderr split, exit codes) without
+// reaching into main()'s internals.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "prompt_generator_bin")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binPath = filepath.Join(dir, "prompt_generator")
+	build := exec.Command("go", "build", "-o", binPath, ".")
+	if out, err := build.CombinedOutput(); err != nil {
+		panic("go build failed: " + err.Error() + "\n" + string(out))
+	}
+
+	os.Exit(m.Run())
+}
+
+var binPath string
+
+// runBinary は binPath を stdin を与えて実行し、標準出力・標準エラー出力・終了コードを返します。
+func runBinary(t *testing.T, stdin string, args ...string) (stdout, stderr string, exitCode int) {
+	t.Helper()
+
+	cmd := exec.Command(binPath, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("failed to run binary: %v", err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	return outBuf.String(), errBuf.String(), exitCode
+}
+
+// TestNoMatchingFilesDoesNotWritePartialHeader は、対象ファイルが1つも見つからない場合に
+// 標準出力へ途中までの構造化ヘッダー（例: `-format json` の `{"files":[`）が残らないことを検証します。
+func TestNoMatchingFilesDoesNotWritePartialHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	stdout, stderr, exitCode := runBinary(t, "", "-p", dir, "-format", "json")
+
+	if exitCode != 1 {
+		t.Fatalf("exitCode = %d, want 1 (stderr: %s)", exitCode, stderr)
+	}
+	if stdout != "" {
+		t.Errorf("stdout = %q, want empty", stdout)
+	}
+	if !strings.Contains(stderr, "有効なファイルが見つかりませんでした") {
+		t.Errorf("stderr = %q, missing expected error message", stderr)
+	}
+}
+
+// TestStreamingWriterProducesPromptWithInstructions は、通常の（テンプレートを使わない）
+// ストリーミング Writer の経路でファイル内容と指示文が正しく標準出力へ書き出されることを検証します。
+func TestStreamingWriterProducesPromptWithInstructions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	stdout, _, exitCode := runBinary(t, "変更してください\n", "-p", dir, "-e", ".go", "-format", "markdown")
+
+	if exitCode != 0 {
+		t.Fatalf("exitCode = %d, want 0", exitCode)
+	}
+	if !strings.Contains(stdout, "main.go") || !strings.Contains(stdout, "package main") {
+		t.Errorf("stdout missing file content: %q", stdout)
+	}
+	if !strings.Contains(stdout, "変更してください") {
+		t.Errorf("stdout missing instructions: %q", stdout)
+	}
+}
+
+// TestTemplateBranchRendersCustomTemplate は、-template 指定時にストリーミング Writer ではなく
+// RenderTemplate 経由でユーザー定義テンプレートが描画されることを検証します。
+func TestTemplateBranchRendersCustomTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	tmplPath := filepath.Join(dir, "tmpl.txt")
+	tmplContent := "FILES:{{range .Files}}{{.Path}}{{end}} INSTR:{{.Instructions}}"
+	if err := os.WriteFile(tmplPath, []byte(tmplContent), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	stdout, _, exitCode := runBinary(t, "hello\n", "-p", dir, "-e", ".go", "-template", tmplPath)
+
+	if exitCode != 0 {
+		t.Fatalf("exitCode = %d, want 0", exitCode)
+	}
+	if !strings.Contains(stdout, filepath.Join(dir, "a.go")) || !strings.Contains(stdout, "INSTR:hello") {
+		t.Errorf("stdout = %q, template not rendered as expected", stdout)
+	}
+}
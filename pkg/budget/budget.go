@@ -0,0 +1,243 @@
+// Package budget はプロンプトのトークン予算を見積もり、予算を超える場合に
+// ファイル内容を縮小するための戦略（truncate / head-tail / skip-largest / summarize）を提供します。
+package budget
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokenizer はテキストのトークン数を見積もります。
+type Tokenizer interface {
+	CountTokens(s string) int
+}
+
+// charsPerToken は HeuristicTokenizer が用いる、おおよそのトークンあたりの文字数です。
+const charsPerToken = 4
+
+// HeuristicTokenizer は「およそ4文字で1トークン」という単純な見積もりを行う既定のトークナイザです。
+// cl100k/o200k などの BPE 語彙テーブルを使った厳密なカウントに差し替えたい場合は、
+// Tokenizer インターフェースを実装したものを各関数に渡してください。
+type HeuristicTokenizer struct{}
+
+func (HeuristicTokenizer) CountTokens(s string) int {
+	return (utf8.RuneCountInString(s) + charsPerToken - 1) / charsPerToken
+}
+
+// Strategy は予算超過時の縮小方法を表します。
+type Strategy string
+
+const (
+	StrategyTruncate    Strategy = "truncate"
+	StrategyHeadTail    Strategy = "head-tail"
+	StrategySkipLargest Strategy = "skip-largest"
+	StrategySummarize   Strategy = "summarize"
+)
+
+// ValidStrategies は -truncate-strategy フラグが取りうる値です。
+var ValidStrategies = []Strategy{StrategyTruncate, StrategyHeadTail, StrategySkipLargest, StrategySummarize}
+
+// FileSize はファイル内容を読まずに把握できる情報で、Plan の構築に使います。
+type FileSize struct {
+	Path string
+	Size int64
+}
+
+// Plan は、与えられたトークン予算・戦略のもとで各ファイルをどう扱うかを決定します。
+type Plan struct {
+	maxTokens int
+	strategy  Strategy
+	tokenizer Tokenizer
+	// ratio < 1.0 の場合、各ファイルをおよそ ratio 倍に縮小すれば全体が予算内に収まる見込みであることを示す。
+	ratio float64
+	// skip は strategy が StrategySkipLargest のときに除外するパスの集合。
+	skip map[string]bool
+}
+
+// NewPlan は files（内容を読まずに集めたファイルサイズ一覧）をもとに Plan を構築します。
+// tokenizer が nil の場合は HeuristicTokenizer を使用します。
+func NewPlan(files []FileSize, maxTokens int, strategy Strategy, tokenizer Tokenizer) *Plan {
+	if tokenizer == nil {
+		tokenizer = HeuristicTokenizer{}
+	}
+
+	p := &Plan{
+		maxTokens: maxTokens,
+		strategy:  strategy,
+		tokenizer: tokenizer,
+		ratio:     1.0,
+		skip:      make(map[string]bool),
+	}
+
+	if maxTokens <= 0 {
+		return p
+	}
+
+	total := estimateTotalTokens(files)
+	if total <= maxTokens {
+		return p
+	}
+
+	if strategy == StrategySkipLargest {
+		p.computeSkipLargest(files, total)
+		return p
+	}
+
+	p.ratio = float64(maxTokens) / float64(total)
+	return p
+}
+
+// estimateTotalTokens はファイルサイズ（バイト）から、内容を読まずにトークン数を概算します。
+func estimateTotalTokens(files []FileSize) int {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return int(total / charsPerToken)
+}
+
+// computeSkipLargest は、予算に収まるまで最もサイズの大きいファイルから順に除外対象としてマークします。
+func (p *Plan) computeSkipLargest(files []FileSize, total int) {
+	sorted := make([]FileSize, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Size > sorted[j].Size })
+
+	remaining := total
+	for _, f := range sorted {
+		if remaining <= p.maxTokens {
+			break
+		}
+		p.skip[f.Path] = true
+		remaining -= int(f.Size / charsPerToken)
+	}
+}
+
+// Affected は、予算の都合でこのファイルが（除外や縮小の対象として）変更されうるかを返します。
+func (p *Plan) Affected(path string) bool {
+	if p.maxTokens <= 0 {
+		return false
+	}
+	if p.strategy == StrategySkipLargest {
+		return p.skip[path]
+	}
+	return p.ratio < 1.0
+}
+
+// Apply は、予算・戦略に従って path のファイルの内容を縮小します。
+// skip が true の場合、このファイルはプロンプトに含めるべきではありません。
+func (p *Plan) Apply(path, content string) (result string, affected bool, skip bool) {
+	if p.maxTokens <= 0 {
+		return content, false, false
+	}
+
+	switch p.strategy {
+	case StrategySkipLargest:
+		if p.skip[path] {
+			return "", true, true
+		}
+		return content, false, false
+
+	case StrategyHeadTail:
+		if p.ratio >= 1.0 {
+			return content, false, false
+		}
+		lines := strings.Split(content, "\n")
+		targetLines := int(float64(len(lines)) * p.ratio)
+		return headTail(lines, targetLines), true, false
+
+	case StrategySummarize:
+		if p.ratio >= 1.0 {
+			return content, false, false
+		}
+		if outline := extractOutline(path, content); outline != "" {
+			return outline, true, false
+		}
+		// アウトラインを抽出できない言語のファイルは head-tail にフォールバックする
+		lines := strings.Split(content, "\n")
+		targetLines := int(float64(len(lines)) * p.ratio)
+		return headTail(lines, targetLines), true, false
+
+	default: // StrategyTruncate
+		if p.ratio >= 1.0 {
+			return content, false, false
+		}
+		byteBudget := int(float64(len(content)) * p.ratio)
+		return truncate(content, byteBudget), true, false
+	}
+}
+
+// Tokens は p に設定されたトークナイザで content のトークン数を見積もります。
+func (p *Plan) Tokens(content string) int {
+	return p.tokenizer.CountTokens(content)
+}
+
+// truncate は content の先頭 byteBudget バイトだけを残し、残りを切り捨てたことを示すマーカーを付けます。
+func truncate(content string, byteBudget int) string {
+	if byteBudget <= 0 {
+		return fmt.Sprintf("... [truncated %d bytes] ...", len(content))
+	}
+	if byteBudget >= len(content) {
+		return content
+	}
+	return content[:byteBudget] + fmt.Sprintf("\n... [truncated %d bytes] ...\n", len(content)-byteBudget)
+}
+
+// headTail は先頭と末尾合わせて targetLines 行だけを残し、間を省略したことを示すマーカーを挟みます。
+func headTail(lines []string, targetLines int) string {
+	if targetLines < 2 {
+		targetLines = 2
+	}
+	if targetLines >= len(lines) {
+		return strings.Join(lines, "\n")
+	}
+
+	headCount := targetLines / 2
+	tailCount := targetLines - headCount
+	omitted := len(lines) - headCount - tailCount
+	marker := fmt.Sprintf("... [truncated %d lines] ...", omitted)
+
+	result := make([]string, 0, targetLines+1)
+	result = append(result, lines[:headCount]...)
+	result = append(result, marker)
+	result = append(result, lines[len(lines)-tailCount:]...)
+	return strings.Join(result, "\n")
+}
+
+// outlinePatterns は拡張子ごとに、トップレベル宣言とみなす行のパターンです。
+var outlinePatterns = map[string]*regexp.Regexp{
+	".go":  regexp.MustCompile(`^(func\s+.+|type\s+\S+.*|var\s+\S+.*|const\s+\S+.*)`),
+	".py":  regexp.MustCompile(`^(def\s+\S+.*|class\s+\S+.*)`),
+	".js":  regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function\s+\S+.*|class\s+\S+.*|const\s+\S+\s*=.*=>.*)`),
+	".jsx": regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function\s+\S+.*|class\s+\S+.*|const\s+\S+\s*=.*=>.*)`),
+	".ts":  regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function\s+\S+.*|class\s+\S+.*|interface\s+\S+.*|const\s+\S+\s*=.*=>.*)`),
+	".tsx": regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function\s+\S+.*|class\s+\S+.*|interface\s+\S+.*|const\s+\S+\s*=.*=>.*)`),
+}
+
+// extractOutline は content からトップレベル（インデントのない）の関数・クラス等の宣言行だけを
+// 正規表現で抜き出します。対応していない拡張子の場合は空文字列を返します。
+func extractOutline(path, content string) string {
+	re, ok := outlinePatterns[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return ""
+	}
+
+	var declarations []string
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimRight(line, " \t")
+		if trimmed == "" || trimmed != strings.TrimLeft(trimmed, " \t") {
+			continue // トップレベル以外（インデントされた行）は対象外
+		}
+		if re.MatchString(trimmed) {
+			declarations = append(declarations, strings.TrimSuffix(trimmed, "{"))
+		}
+	}
+
+	if len(declarations) == 0 {
+		return ""
+	}
+	return strings.Join(declarations, "\n")
+}
@@ -0,0 +1,139 @@
+package budget
+
+import "testing"
+
+func TestPlanApplyTruncate(t *testing.T) {
+	files := []FileSize{{Path: "a.txt", Size: 1000}, {Path: "b.txt", Size: 1000}}
+	plan := NewPlan(files, 100, StrategyTruncate, HeuristicTokenizer{})
+
+	content := "0123456789"
+	repeated := ""
+	for i := 0; i < 100; i++ {
+		repeated += content
+	}
+
+	result, affected, skip := plan.Apply("a.txt", repeated)
+	if skip {
+		t.Fatalf("truncate strategy should never skip a file")
+	}
+	if !affected {
+		t.Errorf("expected content to be affected when over budget")
+	}
+	if len(result) >= len(repeated) {
+		t.Errorf("expected result to be shorter than original, got %d >= %d", len(result), len(repeated))
+	}
+}
+
+func TestPlanApplyTruncateUnderBudgetIsUnaffected(t *testing.T) {
+	files := []FileSize{{Path: "a.txt", Size: 10}}
+	plan := NewPlan(files, 1000, StrategyTruncate, HeuristicTokenizer{})
+
+	result, affected, skip := plan.Apply("a.txt", "hello")
+	if affected || skip {
+		t.Errorf("expected no change when under budget, got affected=%v skip=%v", affected, skip)
+	}
+	if result != "hello" {
+		t.Errorf("expected content unchanged, got %q", result)
+	}
+}
+
+func TestPlanApplyHeadTail(t *testing.T) {
+	files := []FileSize{{Path: "a.txt", Size: 4000}}
+	plan := NewPlan(files, 100, StrategyHeadTail, HeuristicTokenizer{})
+
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	content := ""
+	for _, l := range lines {
+		content += l + "\n"
+	}
+
+	result, affected, skip := plan.Apply("a.txt", content)
+	if skip {
+		t.Fatalf("head-tail strategy should never skip a file")
+	}
+	if !affected {
+		t.Errorf("expected content to be affected when over budget")
+	}
+	if result == content {
+		t.Errorf("expected content to be shortened")
+	}
+}
+
+func TestPlanApplySkipLargest(t *testing.T) {
+	files := []FileSize{
+		{Path: "small.txt", Size: 100},
+		{Path: "large.txt", Size: 10000},
+	}
+	plan := NewPlan(files, 50, StrategySkipLargest, HeuristicTokenizer{})
+
+	if _, _, skip := plan.Apply("small.txt", "hi"); skip {
+		t.Errorf("small.txt should not be skipped")
+	}
+
+	result, affected, skip := plan.Apply("large.txt", "big content")
+	if !skip {
+		t.Errorf("large.txt should be skipped since it is over budget")
+	}
+	if !affected {
+		t.Errorf("skipped file should be reported as affected")
+	}
+	if result != "" {
+		t.Errorf("expected empty result for skipped file, got %q", result)
+	}
+}
+
+func TestPlanApplySummarizeFallsBackToHeadTailForUnsupportedExtension(t *testing.T) {
+	files := []FileSize{{Path: "a.txt", Size: 4000}}
+	plan := NewPlan(files, 100, StrategySummarize, HeuristicTokenizer{})
+
+	lines := make([]string, 200)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	content := ""
+	for _, l := range lines {
+		content += l + "\n"
+	}
+
+	result, affected, skip := plan.Apply("a.txt", content)
+	if skip {
+		t.Fatalf("summarize strategy should never skip a file")
+	}
+	if !affected || result == content {
+		t.Errorf("expected head-tail fallback to shorten unsupported extension content")
+	}
+}
+
+func TestPlanApplySummarizeExtractsOutline(t *testing.T) {
+	files := []FileSize{{Path: "a.go", Size: 4000}}
+	plan := NewPlan(files, 10, StrategySummarize, HeuristicTokenizer{})
+
+	content := "package main\n\nfunc Foo() {\n\tdoSomething()\n}\n\nfunc Bar() {\n\tdoSomethingElse()\n}\n"
+	result, affected, skip := plan.Apply("a.go", content)
+	if skip {
+		t.Fatalf("summarize strategy should never skip a file")
+	}
+	if !affected {
+		t.Errorf("expected outline extraction to affect content")
+	}
+	if result == content {
+		t.Errorf("expected outline, got unchanged content")
+	}
+}
+
+func TestPlanApplyUnderBudgetNeverAffectsAnyStrategy(t *testing.T) {
+	files := []FileSize{{Path: "a.go", Size: 10}}
+	for _, strategy := range ValidStrategies {
+		plan := NewPlan(files, 1000, strategy, HeuristicTokenizer{})
+		result, affected, skip := plan.Apply("a.go", "package main\n")
+		if affected || skip {
+			t.Errorf("strategy %s: expected no change when under budget", strategy)
+		}
+		if result != "package main\n" {
+			t.Errorf("strategy %s: expected content unchanged, got %q", strategy, result)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+// Package collector は、対象ディレクトリを走査してテキストファイルの内容を集める処理
+// （.gitignore 等による除外判定、バイナリ判定、文字エンコーディングの検出・変換）を提供します。
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/plumbing/format/gitignore"
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// DefaultAllowedContentTypes はテキストとみなす MIME タイプの既定値です。
+// "text/*" のようなワイルドカードと、"+json"/"+xml" サフィックスは IsAllowedContentType 側で別途許可されます。
+var DefaultAllowedContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/xml",
+	"application/ecmascript",
+	"application/x-ndjson",
+}
+
+// Options は Collect の挙動を制御します。
+type Options struct {
+	// Extensions は収集対象の拡張子（"." を含む）です。"." が含まれる場合はすべてのファイルを対象とします。
+	Extensions []string
+	// Matcher は .gitignore 等から構築された除外ルールです。nil の場合は除外を行いません。
+	Matcher gitignore.Matcher
+	// EncodingName が空でなければ、自動検出の代わりにこのエンコーディングを使用します。
+	EncodingName string
+	// Verbose が true の場合、検出したエンコーディングと信頼度を標準エラー出力に表示します。
+	Verbose bool
+	// IncludeBinary が true の場合、バイナリと判定されたファイルも除外せずに含めます。
+	IncludeBinary bool
+	// AllowedContentTypes はテキストとして扱う MIME タイプです。空の場合は DefaultAllowedContentTypes を使用します。
+	AllowedContentTypes []string
+}
+
+// ResolvedAllowedContentTypes は AllowedContentTypes が空の場合に DefaultAllowedContentTypes を補って返します。
+func (o Options) ResolvedAllowedContentTypes() []string {
+	if len(o.AllowedContentTypes) == 0 {
+		return DefaultAllowedContentTypes
+	}
+	return o.AllowedContentTypes
+}
+
+// getEncodingByName returns an encoding.Encoding by name
+func getEncodingByName(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "_", "-")) {
+	case "shift-jis", "shiftjis", "sjis":
+		return japanese.ShiftJIS, nil
+	case "euc-jp", "eucjp":
+		return japanese.EUCJP, nil
+	case "iso-2022-jp", "iso2022jp":
+		return japanese.ISO2022JP, nil
+	case "utf-16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case "utf-16be":
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	case "utf-8", "utf8":
+		return unicode.UTF8, nil
+	case "gb18030", "gb-18030":
+		return simplifiedchinese.GB18030, nil
+	case "big5":
+		return traditionalchinese.Big5, nil
+	case "euc-kr", "euckr":
+		return korean.EUCKR, nil
+	case "windows-1252", "windows1252", "cp1252":
+		return charmap.Windows1252, nil
+	default:
+		return nil, fmt.Errorf("サポートされていないエンコーディング: %s", name)
+	}
+}
+
+// GetEncodingByName はエンコーディング名から encoding.Encoding を取得します。
+func GetEncodingByName(name string) (encoding.Encoding, error) {
+	return getEncodingByName(name)
+}
+
+// stripBOM は UTF-8/UTF-16 の BOM を検出して取り除きます。
+// BOM が見つかった場合は、対応するエンコーディング名とともに BOM 除去後のデータを返します。
+// 見つからなかった場合は encodingName は空文字列です。
+func stripBOM(data []byte) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:], "utf-8"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return data[2:], "utf-16be"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return data[2:], "utf-16le"
+	default:
+		return data, ""
+	}
+}
+
+// sortDetectionResults は chardet の結果を信頼度の降順、同点の場合は charset 名の昇順で
+// 安定的に並べ替えます。DetectAll は複数候補が同じ confidence を持つ場合の順序を保証しないため、
+// 実行のたびに結果が変わらないようにここで明示的にソートします。
+func sortDetectionResults(results []chardet.Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Confidence != results[j].Confidence {
+			return results[i].Confidence > results[j].Confidence
+		}
+		return results[i].Charset < results[j].Charset
+	})
+}
+
+// DetectAndConvertEncoding attempts to detect the encoding of the given data and convert it to UTF-8.
+// 戻り値の detectedEncoding は、実際に採用されたエンコーディング名（不明な場合は "unknown"）です。
+func DetectAndConvertEncoding(data []byte, encodingName string, verbose bool) (content string, detectedEncoding string, err error) {
+	// If encoding is specified, use that
+	if encodingName != "" {
+		enc, err := getEncodingByName(encodingName)
+		if err != nil {
+			return "", "", fmt.Errorf("指定されたエンコーディング '%s' が見つかりません: %v", encodingName, err)
+		}
+		decoder := enc.NewDecoder()
+		result, err := decoder.Bytes(data)
+		if err != nil {
+			return "", "", fmt.Errorf("指定されたエンコーディング '%s' でデコードできませんでした: %v", encodingName, err)
+		}
+		return string(result), encodingName, nil
+	}
+
+	// BOM があれば取り除き、その時点でエンコーディングが確定する
+	stripped, bomEncoding := stripBOM(data)
+	if bomEncoding != "" {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "detected encoding: %s (confidence: BOM)\n", bomEncoding)
+		}
+		if bomEncoding == "utf-8" {
+			return string(stripped), bomEncoding, nil
+		}
+		enc, _ := getEncodingByName(bomEncoding)
+		result, err := enc.NewDecoder().Bytes(stripped)
+		if err != nil {
+			return "", "", fmt.Errorf("BOM付き '%s' としてデコードできませんでした: %v", bomEncoding, err)
+		}
+		return string(result), bomEncoding, nil
+	}
+	data = stripped
+
+	// First check if it's already valid UTF-8
+	if utf8.Valid(data) {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "detected encoding: UTF-8 (confidence: 100)\n")
+		}
+		return string(data), "utf-8", nil
+	}
+
+	// chardet で候補を列挙し、confidence 降順・charset 名昇順（同点タイブレーク）で並べ替える
+	results, err := chardet.NewTextDetector().DetectAll(data)
+	if err == nil {
+		sortDetectionResults(results)
+		for _, candidate := range results {
+			enc, encErr := getEncodingByName(candidate.Charset)
+			if encErr != nil {
+				continue
+			}
+			result, decErr := enc.NewDecoder().Bytes(data)
+			if decErr == nil && utf8.Valid(result) {
+				if verbose {
+					fmt.Fprintf(os.Stderr, "detected encoding: %s (confidence: %d)\n", candidate.Charset, candidate.Confidence)
+				}
+				return string(result), candidate.Charset, nil
+			}
+		}
+	}
+
+	// If we can't determine the encoding, just return as is with a warning
+	fmt.Fprintf(os.Stderr, "警告: ファイルのエンコーディングを検出できませんでした。UTF-8として処理します。\n")
+	return string(data), "unknown", nil
+}
+
+// IsAllowedContentType は mimeType が allowed に含まれる（あるいは "type/*" ワイルドカードに一致する）かどうかを判定します。
+// "+json"/"+xml" サフィックスを持つタイプ（例: application/ld+json）は allowed の内容に関わらず常にテキストとして許可します。
+func IsAllowedContentType(mimeType string, allowed []string) bool {
+	if strings.HasSuffix(mimeType, "+json") || strings.HasSuffix(mimeType, "+xml") {
+		return true
+	}
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok {
+			if strings.HasPrefix(mimeType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mimeType == a {
+			return true
+		}
+	}
+	return false
+}
+
+// binarySniffSize はバイナリ判定のために先頭から読み取るバイト数です。
+const binarySniffSize = 8192
+
+// invalidRuneRatioThreshold を超えて不正な UTF-8 シーケンス（U+FFFD, size==1）が含まれる場合、
+// テキストではなくバイナリとみなします。
+const invalidRuneRatioThreshold = 0.1
+
+// isUTF16BOM は data が UTF-16（BE/LE）の BOM で始まるかどうかを判定します。
+// UTF-16 でエンコードされた ASCII 相当の文字は 1 バイトおきに NUL バイトを含むため、
+// IsBinary の NUL バイトチェックはこの場合だけ適用しません。
+func isUTF16BOM(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0xFE, 0xFF}) || bytes.HasPrefix(data, []byte{0xFF, 0xFE})
+}
+
+// IsBinary は data（デコード前の生バイト列）がバイナリファイルの内容かどうかを判定します。
+// (a) UTF-16 の BOM を伴わないのに先頭 binarySniffSize バイトに NUL バイトが含まれるか、
+// (b) http.DetectContentType が allowedContentTypes のいずれにも一致しないか、
+// のいずれかに該当すればバイナリと判定します。
+// この判定はエンコーディング変換より前に行うため、変換すれば正しく読めるだけの
+// Shift-JIS や EUC-JP のようなテキストを誤ってバイナリとみなさないよう、
+// UTF-8 としての妥当性はここではチェックしません（hasHighInvalidRuneRatio を参照）。
+func IsBinary(data []byte, allowedContentTypes []string) bool {
+	sniff := data
+	if len(sniff) > binarySniffSize {
+		sniff = sniff[:binarySniffSize]
+	}
+
+	if !isUTF16BOM(data) && bytes.IndexByte(sniff, 0) != -1 {
+		return true
+	}
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(sniff), ";")
+	return !IsAllowedContentType(strings.TrimSpace(mimeType), allowedContentTypes)
+}
+
+// hasHighInvalidRuneRatio は s（エンコーディング変換後の内容）の中で UTF-8 として不正な
+// バイト列（U+FFFD かつ size==1）の比率が閾値を超えているかどうかを判定します。
+// DetectAndConvertEncoding が対応するエンコーディングを見つけられず生バイト列をそのまま
+// 返した場合（detectedEncoding == "unknown"）に、実は文字化けしたバイナリだったことを検出するために使います。
+func hasHighInvalidRuneRatio(s string) bool {
+	sniff := s
+	if len(sniff) > binarySniffSize {
+		sniff = sniff[:binarySniffSize]
+	}
+
+	var totalRunes, invalidRunes int
+	for len(sniff) > 0 {
+		r, size := utf8.DecodeRuneInString(sniff)
+		totalRunes++
+		if r == utf8.RuneError && size == 1 {
+			invalidRunes++
+		}
+		sniff = sniff[size:]
+	}
+	return totalRunes > 0 && float64(invalidRunes)/float64(totalRunes) > invalidRuneRatioThreshold
+}
+
+// SniffIsBinary はファイル全体を読み込まず、先頭 binarySniffSize バイトだけを読んで
+// IsBinary によるバイナリ判定を行います。トークン予算の見積もりのように、内容全体を
+// 読まずにバイナリファイルを除外したい用途で使います。
+func SniffIsBinary(path string, allowedContentTypes []string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, binarySniffSize)
+	n, err := f.Read(buf)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return IsBinary(buf[:n], allowedContentTypes), nil
+}
+
+// readPatternFile はテキストファイルを gitignore 形式のパターンとして読み取ります。
+// domain はそのファイルが存在するディレクトリの、ツリールートからの相対パスを表します。
+func readPatternFile(path string, domain []string) ([]gitignore.Pattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ps []gitignore.Pattern
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ps = append(ps, gitignore.ParsePattern(line, domain))
+	}
+	return ps, nil
+}
+
+// readExportIgnorePatterns は .gitattributes の export-ignore 属性が付いたパターンを
+// 通常の gitignore 除外パターンとして読み取ります（"-export-ignore" による解除は無視対象としません）。
+func readExportIgnorePatterns(path string, domain []string) ([]gitignore.Pattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ps []gitignore.Pattern
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if slices.Contains(fields[1:], "export-ignore") {
+			ps = append(ps, gitignore.ParsePattern(fields[0], domain))
+		}
+	}
+	return ps, nil
+}
+
+// loadRepositoryPatterns は absInputPath 以下を再帰的に走査し、各ディレクトリの .gitignore、
+// .gitattributes の export-ignore 指定、および extraIgnoreFiles で指定された追加の無視ファイル
+// （.dockerignore, .npmignore など）からパターンを収集します。
+// .git ディレクトリ自体は走査しません。
+func loadRepositoryPatterns(absInputPath string, extraIgnoreFiles []string) ([]gitignore.Pattern, error) {
+	var ps []gitignore.Pattern
+
+	err := filepath.Walk(absInputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" && path != absInputPath {
+			return filepath.SkipDir
+		}
+
+		relDir, err := filepath.Rel(absInputPath, path)
+		if err != nil {
+			return err
+		}
+		var domain []string
+		if relDir != "." {
+			domain = strings.Split(relDir, string(os.PathSeparator))
+		}
+
+		gitignorePs, err := readPatternFile(filepath.Join(path, ".gitignore"), domain)
+		if err != nil {
+			return err
+		}
+		ps = append(ps, gitignorePs...)
+
+		attrPs, err := readExportIgnorePatterns(filepath.Join(path, ".gitattributes"), domain)
+		if err != nil {
+			return err
+		}
+		ps = append(ps, attrPs...)
+
+		for _, name := range extraIgnoreFiles {
+			extraPs, err := readPatternFile(filepath.Join(path, name), domain)
+			if err != nil {
+				return err
+			}
+			ps = append(ps, extraPs...)
+		}
+
+		return nil
+	})
+
+	return ps, err
+}
+
+// LoadIgnoreMatcher は git の除外ルールの優先順位
+// （システム全体の core.excludesFile < ユーザーの core.excludesFile < .git/info/exclude <
+// ツリー内の .gitignore/.gitattributes(export-ignore)/追加の無視ファイル、後者ほど優先）
+// に従ってパターンを集約し、Matcher を構築します。
+func LoadIgnoreMatcher(absInputPath string, extraIgnoreFiles []string) (gitignore.Matcher, error) {
+	var ps []gitignore.Pattern
+
+	rootFS := osfs.New(string(os.PathSeparator))
+	if sysPs, err := gitignore.LoadSystemPatterns(rootFS); err == nil {
+		ps = append(ps, sysPs...)
+	}
+	if globalPs, err := gitignore.LoadGlobalPatterns(rootFS); err == nil {
+		ps = append(ps, globalPs...)
+	}
+
+	excludePs, err := readPatternFile(filepath.Join(absInputPath, ".git", "info", "exclude"), nil)
+	if err != nil {
+		return nil, err
+	}
+	ps = append(ps, excludePs...)
+
+	repoPs, err := loadRepositoryPatterns(absInputPath, extraIgnoreFiles)
+	if err != nil {
+		return nil, err
+	}
+	ps = append(ps, repoPs...)
+
+	return gitignore.NewMatcher(ps), nil
+}
+
+// ListCandidatePaths は拡張子・.gitignore による絞り込みだけを行い（内容は読まない）、
+// 対象となりうるファイルの絶対パス一覧を返します。トークン予算の見積もりなど、
+// ファイル内容を読まずに対象ファイルを把握したい用途にも利用できます。
+func ListCandidatePaths(absInputPath string, opts Options) ([]string, error) {
+	var paths []string
+
+	err := filepath.Walk(absInputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "パスへのアクセスエラー: %v\n", err)
+			return nil
+		}
+
+		relPath, err := filepath.Rel(absInputPath, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "相対パス取得エラー: %v\n", err)
+			return nil
+		}
+
+		// 隠しディレクトリ or 隠しファイルは無視（ただし .gitignore は例外）
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			if info.Name() != ".gitignore" {
+				return nil
+			}
+		}
+
+		if opts.Matcher != nil && opts.Matcher.Match(strings.Split(relPath, string(os.PathSeparator)), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		// もし拡張子リストに "." が含まれていたら、すべてのファイルを対象
+		// 含まれていなければ、通常通り拡張子チェックを行う
+		if !slices.Contains(opts.Extensions, ".") {
+			if !slices.Contains(opts.Extensions, filepath.Ext(path)) {
+				return nil
+			}
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+
+	return paths, err
+}
+
+// Collect は absInputPath 以下のテキストファイルの内容を収集し、ソート済みのパス順に
+// visit を呼び出します。visit には検出されたエンコーディング名（不明な場合は "unknown"）も渡されます。
+// visit がエラーを返した場合は直ちに処理を中断してそのエラーを返します。
+// ファイル内容をまとめて保持することなく、1 ファイルずつ読み取ってから visit に渡すため、
+// 非常に大きなリポジトリを処理してもメモリ使用量は抑えられます。
+//
+// バイナリ判定は 2 段階で行います。まず変換前の生バイト列に対して IsBinary で
+// 明らかなバイナリ（画像や実行ファイルなど）を安価に弾き、次にエンコーディング変換後の
+// 内容に対して hasHighInvalidRuneRatio でチェックします。これは Shift-JIS や EUC-JP のような
+// 非 UTF-8 テキストを変換前の生バイト列だけで誤ってバイナリと判定しないようにするためです。
+func Collect(absInputPath string, opts Options, visit func(path string, content string, detectedEncoding string) error) error {
+	allowedContentTypes := opts.ResolvedAllowedContentTypes()
+
+	paths, err := ListCandidatePaths(absInputPath, opts)
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ファイル読み取りエラー: %v\n", err)
+			continue
+		}
+
+		if !opts.IncludeBinary && IsBinary(data, allowedContentTypes) {
+			if opts.Verbose {
+				fmt.Fprintf(os.Stderr, "バイナリファイルとして除外: %s\n", path)
+			}
+			continue
+		}
+
+		content, detectedEncoding, err := DetectAndConvertEncoding(data, opts.EncodingName, opts.Verbose)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エンコーディング変換エラー (%s): %v\n", path, err)
+			continue
+		}
+
+		if !opts.IncludeBinary && hasHighInvalidRuneRatio(content) {
+			if opts.Verbose {
+				fmt.Fprintf(os.Stderr, "バイナリファイルとして除外（変換後も不正なバイト列が多い）: %s\n", path)
+			}
+			continue
+		}
+
+		if err := visit(path, content, detectedEncoding); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
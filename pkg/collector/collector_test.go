@@ -0,0 +1,353 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/saintfish/chardet"
+)
+
+// writeTestFile creates dir (and its parents) if needed and writes content to name under dir.
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll(%s): %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile(%s): %v", path, err)
+	}
+}
+
+// TestLoadIgnoreMatcherNestedDomainsAndNegation は、ネストした .gitignore がそれぞれ自分の
+// ディレクトリ配下（domain）にのみ適用されること、否定パターン（!pattern）による再包含、
+// .git/info/exclude、.gitattributes の export-ignore が正しく組み合わさることを検証します。
+func TestLoadIgnoreMatcherNestedDomainsAndNegation(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, root, ".gitignore", "*.log\nbuild/\n")
+	writeTestFile(t, root, "sub/.gitignore", "secret.txt\n!keep/secret.txt\n")
+	writeTestFile(t, root, ".git/info/exclude", "ignored_by_exclude.txt\n")
+	writeTestFile(t, root, ".gitattributes", "export_ignore.txt export-ignore\n")
+
+	writeTestFile(t, root, "normal.go", "package main\n")
+	writeTestFile(t, root, "app.log", "log\n")
+	writeTestFile(t, root, "build/output.txt", "x\n")
+	writeTestFile(t, root, "sub/a.go", "package sub\n")
+	writeTestFile(t, root, "sub/secret.txt", "secret\n")
+	writeTestFile(t, root, "sub/keep/secret.txt", "kept secret\n")
+	writeTestFile(t, root, "ignored_by_exclude.txt", "x\n")
+	writeTestFile(t, root, "export_ignore.txt", "x\n")
+
+	matcher, err := LoadIgnoreMatcher(root, nil)
+	if err != nil {
+		t.Fatalf("LoadIgnoreMatcher: %v", err)
+	}
+
+	paths, err := ListCandidatePaths(root, Options{Extensions: []string{"."}, Matcher: matcher})
+	if err != nil {
+		t.Fatalf("ListCandidatePaths: %v", err)
+	}
+
+	var relPaths []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatalf("Rel: %v", err)
+		}
+		relPaths = append(relPaths, filepath.ToSlash(rel))
+	}
+
+	wantIncluded := []string{"normal.go", "sub/a.go", "sub/keep/secret.txt"}
+	for _, want := range wantIncluded {
+		if !slices.Contains(relPaths, want) {
+			t.Errorf("expected %q to be included, got %v", want, relPaths)
+		}
+	}
+
+	wantExcluded := []string{"app.log", "build/output.txt", "sub/secret.txt", "ignored_by_exclude.txt", "export_ignore.txt"}
+	for _, unwanted := range wantExcluded {
+		if slices.Contains(relPaths, unwanted) {
+			t.Errorf("expected %q to be excluded, got %v", unwanted, relPaths)
+		}
+	}
+}
+
+// TestLoadIgnoreMatcherNestedGitignoreScopedToOwnDirectory は、あるディレクトリの .gitignore に
+// 書かれたパターンが、同名ファイルであっても別ディレクトリには影響しないことを検証します。
+func TestLoadIgnoreMatcherNestedGitignoreScopedToOwnDirectory(t *testing.T) {
+	root := t.TempDir()
+
+	writeTestFile(t, root, "sub/.gitignore", "local.txt\n")
+	writeTestFile(t, root, "sub/local.txt", "ignored here\n")
+	writeTestFile(t, root, "other/local.txt", "not ignored here\n")
+
+	matcher, err := LoadIgnoreMatcher(root, nil)
+	if err != nil {
+		t.Fatalf("LoadIgnoreMatcher: %v", err)
+	}
+
+	paths, err := ListCandidatePaths(root, Options{Extensions: []string{"."}, Matcher: matcher})
+	if err != nil {
+		t.Fatalf("ListCandidatePaths: %v", err)
+	}
+
+	var relPaths []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatalf("Rel: %v", err)
+		}
+		relPaths = append(relPaths, filepath.ToSlash(rel))
+	}
+
+	if slices.Contains(relPaths, "sub/local.txt") {
+		t.Errorf("expected sub/local.txt to be excluded by sub/.gitignore, got %v", relPaths)
+	}
+	if !slices.Contains(relPaths, "other/local.txt") {
+		t.Errorf("expected other/local.txt to remain included, got %v", relPaths)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{
+			name: "plain ASCII text",
+			data: []byte("package main\n\nfunc main() {}\n"),
+			want: false,
+		},
+		{
+			name: "NUL byte",
+			data: []byte("abc\x00def"),
+			want: true,
+		},
+		{
+			name: "PNG header",
+			data: []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A, 0x00, 0x00, 0x00, 0x0D},
+			want: true,
+		},
+		{
+			name: "UTF-16LE BOM with ASCII text has interleaved NUL bytes but is not binary",
+			data: append([]byte{0xFF, 0xFE}, []byte("h\x00e\x00l\x00l\x00o\x00")...),
+			want: false,
+		},
+		{
+			name: "UTF-16BE BOM with ASCII text has interleaved NUL bytes but is not binary",
+			data: append([]byte{0xFE, 0xFF}, []byte("\x00h\x00e\x00l\x00l\x00o")...),
+			want: false,
+		},
+		{
+			name: "Shift-JIS text is not classified as binary from raw bytes alone",
+			data: []byte{0x82, 0xa0, 0x82, 0xa2, 0x82, 0xa4}, // 「あいう」 in Shift-JIS
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBinary(tt.data, DefaultAllowedContentTypes); got != tt.want {
+				t.Errorf("IsBinary(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasHighInvalidRuneRatio(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{name: "valid UTF-8 text", s: "こんにちは、世界", want: false},
+		{name: "empty string", s: "", want: false},
+		{
+			name: "mostly invalid bytes reinterpreted as UTF-8",
+			s:    string([]byte{0x82, 0xa0, 0x82, 0xa2, 0x82, 0xa4, 0x82, 0xa6, 0x82, 0xa8}),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasHighInvalidRuneRatio(tt.s); got != tt.want {
+				t.Errorf("hasHighInvalidRuneRatio(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripBOM(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		wantStripped []byte
+		wantEncoding string
+	}{
+		{
+			name:         "UTF-8 BOM",
+			data:         append([]byte{0xEF, 0xBB, 0xBF}, []byte("hello")...),
+			wantStripped: []byte("hello"),
+			wantEncoding: "utf-8",
+		},
+		{
+			name:         "UTF-16BE BOM",
+			data:         append([]byte{0xFE, 0xFF}, []byte("hello")...),
+			wantStripped: []byte("hello"),
+			wantEncoding: "utf-16be",
+		},
+		{
+			name:         "UTF-16LE BOM",
+			data:         append([]byte{0xFF, 0xFE}, []byte("hello")...),
+			wantStripped: []byte("hello"),
+			wantEncoding: "utf-16le",
+		},
+		{
+			name:         "no BOM",
+			data:         []byte("hello"),
+			wantStripped: []byte("hello"),
+			wantEncoding: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stripped, encodingName := stripBOM(tt.data)
+			if string(stripped) != string(tt.wantStripped) || encodingName != tt.wantEncoding {
+				t.Errorf("stripBOM(%q) = (%q, %q), want (%q, %q)", tt.data, stripped, encodingName, tt.wantStripped, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestSortDetectionResults(t *testing.T) {
+	results := []chardet.Result{
+		{Charset: "GB-18030", Confidence: 10},
+		{Charset: "Shift_JIS", Confidence: 100},
+		{Charset: "Big5", Confidence: 10},
+		{Charset: "EUC-JP", Confidence: 50},
+	}
+
+	sortDetectionResults(results)
+
+	want := []string{"Shift_JIS", "EUC-JP", "Big5", "GB-18030"}
+	for i, charset := range want {
+		if results[i].Charset != charset {
+			t.Errorf("results[%d].Charset = %q, want %q (order: %v)", i, results[i].Charset, charset, results)
+		}
+	}
+}
+
+func TestDetectAndConvertEncodingExplicit(t *testing.T) {
+	data := []byte{0x82, 0xb1, 0x82, 0xf1} // "こん" in Shift-JIS
+	content, detectedEncoding, err := DetectAndConvertEncoding(data, "shift-jis", false)
+	if err != nil {
+		t.Fatalf("DetectAndConvertEncoding: %v", err)
+	}
+	if want := "こん"; content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	if detectedEncoding != "shift-jis" {
+		t.Errorf("detectedEncoding = %q, want %q", detectedEncoding, "shift-jis")
+	}
+}
+
+func TestDetectAndConvertEncodingExplicitUnsupported(t *testing.T) {
+	if _, _, err := DetectAndConvertEncoding([]byte("x"), "no-such-encoding", false); err == nil {
+		t.Fatal("expected an error for an unsupported explicit encoding, got nil")
+	}
+}
+
+func TestDetectAndConvertEncodingBOM(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		wantContent  string
+		wantEncoding string
+	}{
+		{
+			name:         "UTF-8 BOM",
+			data:         append([]byte{0xEF, 0xBB, 0xBF}, []byte("hello")...),
+			wantContent:  "hello",
+			wantEncoding: "utf-8",
+		},
+		{
+			name:         "UTF-16LE BOM",
+			data:         append([]byte{0xFF, 0xFE}, []byte("h\x00e\x00l\x00l\x00o\x00")...),
+			wantContent:  "hello",
+			wantEncoding: "utf-16le",
+		},
+		{
+			name:         "UTF-16BE BOM",
+			data:         append([]byte{0xFE, 0xFF}, []byte("\x00h\x00e\x00l\x00l\x00o")...),
+			wantContent:  "hello",
+			wantEncoding: "utf-16be",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, detectedEncoding, err := DetectAndConvertEncoding(tt.data, "", false)
+			if err != nil {
+				t.Fatalf("DetectAndConvertEncoding: %v", err)
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if detectedEncoding != tt.wantEncoding {
+				t.Errorf("detectedEncoding = %q, want %q", detectedEncoding, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+// TestDetectAndConvertEncodingAutoDetect は、エンコーディング未指定・BOM なしの場合に
+// chardet による自動検出が Shift-JIS/EUC-JP/GB18030 の実データを正しくデコードすることを検証します。
+// 短い文字列では chardet の confidence が割れて別候補が先に試されてしまうため、
+// 確実に最有力候補が選ばれるよう、ある程度の長さの実文を使っています。
+func TestDetectAndConvertEncodingAutoDetect(t *testing.T) {
+	const wantJapanese = "吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。何でも薄暗いじめじめした所でニャーニャー泣いていた事だけは記憶している。"
+	const wantChinese = "我是一只猫。我还没有名字。我不知道自己是在哪里出生的。只记得在一个阴暗潮湿的地方喵喵地哭泣。"
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantContent string
+	}{
+		{
+			name:        "Shift-JIS",
+			data:        []byte{0x8c, 0xe1, 0x94, 0x79, 0x82, 0xcd, 0x94, 0x4c, 0x82, 0xc5, 0x82, 0xa0, 0x82, 0xe9, 0x81, 0x42, 0x96, 0xbc, 0x91, 0x4f, 0x82, 0xcd, 0x82, 0xdc, 0x82, 0xbe, 0x96, 0xb3, 0x82, 0xa2, 0x81, 0x42, 0x82, 0xc7, 0x82, 0xb1, 0x82, 0xc5, 0x90, 0xb6, 0x82, 0xea, 0x82, 0xbd, 0x82, 0xa9, 0x82, 0xc6, 0x82, 0xf1, 0x82, 0xc6, 0x8c, 0xa9, 0x93, 0x96, 0x82, 0xaa, 0x82, 0xc2, 0x82, 0xa9, 0x82, 0xca, 0x81, 0x42, 0x89, 0xbd, 0x82, 0xc5, 0x82, 0xe0, 0x94, 0x96, 0x88, 0xc3, 0x82, 0xa2, 0x82, 0xb6, 0x82, 0xdf, 0x82, 0xb6, 0x82, 0xdf, 0x82, 0xb5, 0x82, 0xbd, 0x8f, 0x8a, 0x82, 0xc5, 0x83, 0x6a, 0x83, 0x83, 0x81, 0x5b, 0x83, 0x6a, 0x83, 0x83, 0x81, 0x5b, 0x8b, 0x83, 0x82, 0xa2, 0x82, 0xc4, 0x82, 0xa2, 0x82, 0xbd, 0x8e, 0x96, 0x82, 0xbe, 0x82, 0xaf, 0x82, 0xcd, 0x8b, 0x4c, 0x89, 0xaf, 0x82, 0xb5, 0x82, 0xc4, 0x82, 0xa2, 0x82, 0xe9, 0x81, 0x42},
+			wantContent: wantJapanese,
+		},
+		{
+			name:        "EUC-JP",
+			data:        []byte{0xb8, 0xe3, 0xc7, 0xda, 0xa4, 0xcf, 0xc7, 0xad, 0xa4, 0xc7, 0xa4, 0xa2, 0xa4, 0xeb, 0xa1, 0xa3, 0xcc, 0xbe, 0xc1, 0xb0, 0xa4, 0xcf, 0xa4, 0xde, 0xa4, 0xc0, 0xcc, 0xb5, 0xa4, 0xa4, 0xa1, 0xa3, 0xa4, 0xc9, 0xa4, 0xb3, 0xa4, 0xc7, 0xc0, 0xb8, 0xa4, 0xec, 0xa4, 0xbf, 0xa4, 0xab, 0xa4, 0xc8, 0xa4, 0xf3, 0xa4, 0xc8, 0xb8, 0xab, 0xc5, 0xf6, 0xa4, 0xac, 0xa4, 0xc4, 0xa4, 0xab, 0xa4, 0xcc, 0xa1, 0xa3, 0xb2, 0xbf, 0xa4, 0xc7, 0xa4, 0xe2, 0xc7, 0xf6, 0xb0, 0xc5, 0xa4, 0xa4, 0xa4, 0xb8, 0xa4, 0xe1, 0xa4, 0xb8, 0xa4, 0xe1, 0xa4, 0xb7, 0xa4, 0xbf, 0xbd, 0xea, 0xa4, 0xc7, 0xa5, 0xcb, 0xa5, 0xe3, 0xa1, 0xbc, 0xa5, 0xcb, 0xa5, 0xe3, 0xa1, 0xbc, 0xb5, 0xe3, 0xa4, 0xa4, 0xa4, 0xc6, 0xa4, 0xa4, 0xa4, 0xbf, 0xbb, 0xf6, 0xa4, 0xc0, 0xa4, 0xb1, 0xa4, 0xcf, 0xb5, 0xad, 0xb2, 0xb1, 0xa4, 0xb7, 0xa4, 0xc6, 0xa4, 0xa4, 0xa4, 0xeb, 0xa1, 0xa3},
+			wantContent: wantJapanese,
+		},
+		{
+			name:        "GB18030",
+			data:        []byte{0xce, 0xd2, 0xca, 0xc7, 0xd2, 0xbb, 0xd6, 0xbb, 0xc3, 0xa8, 0xa1, 0xa3, 0xce, 0xd2, 0xbb, 0xb9, 0xc3, 0xbb, 0xd3, 0xd0, 0xc3, 0xfb, 0xd7, 0xd6, 0xa1, 0xa3, 0xce, 0xd2, 0xb2, 0xbb, 0xd6, 0xaa, 0xb5, 0xc0, 0xd7, 0xd4, 0xbc, 0xba, 0xca, 0xc7, 0xd4, 0xda, 0xc4, 0xc4, 0xc0, 0xef, 0xb3, 0xf6, 0xc9, 0xfa, 0xb5, 0xc4, 0xa1, 0xa3, 0xd6, 0xbb, 0xbc, 0xc7, 0xb5, 0xc3, 0xd4, 0xda, 0xd2, 0xbb, 0xb8, 0xf6, 0xd2, 0xf5, 0xb0, 0xb5, 0xb3, 0xb1, 0xca, 0xaa, 0xb5, 0xc4, 0xb5, 0xd8, 0xb7, 0xbd, 0xdf, 0xf7, 0xdf, 0xf7, 0xb5, 0xd8, 0xbf, 0xde, 0xc6, 0xfc, 0xa1, 0xa3},
+			wantContent: wantChinese,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, detectedEncoding, err := DetectAndConvertEncoding(tt.data, "", false)
+			if err != nil {
+				t.Fatalf("DetectAndConvertEncoding: %v", err)
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+			if detectedEncoding == "unknown" {
+				t.Errorf("detectedEncoding = %q, want a detected charset", detectedEncoding)
+			}
+		})
+	}
+}
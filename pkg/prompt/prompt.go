@@ -0,0 +1,246 @@
+// Package prompt はリポジトリのファイル内容と指示文を組み合わせたプロンプトを
+// いくつかの出力形式で io.Writer へ書き出します。
+package prompt
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// File はプロンプトに含める 1 ファイル分の情報です。
+type File struct {
+	Path             string
+	Content          string
+	DetectedEncoding string
+}
+
+// Document はテンプレート出力向けに、全ファイルと指示文をまとめて保持します。
+// -template で任意の text/template を使う場合にのみ利用され、他の形式はストリーミングで書き出します。
+type Document struct {
+	Files        []File
+	Instructions string
+}
+
+// Writer はプロンプトをセクションごとに直接 io.Writer へ書き出します。
+// すべてのファイル内容をメモリ上に溜め込む必要がないため、巨大なリポジトリでも利用できます。
+// WriteHeader/WriteFile/WriteInstructions は同じ渡された io.Writer に順番に書き込むため、
+// 呼び出し側はヘッダーからインストラクションまでの間にその Writer（特に os.Stdout）へ他の
+// 出力を挟んではいけません。挟むと plain/markdown 以外の構造化形式（xml, json）では
+// ドキュメントの途中に無関係なテキストが混入し、出力として成立しなくなります。
+// 対話的なメッセージや進捗表示は os.Stderr に書き出してください。
+type Writer interface {
+	WriteHeader() error
+	WriteFile(f File) error
+	WriteInstructions(instructions string) error
+}
+
+// Format は -format フラグで選択できる出力形式です。
+type Format string
+
+const (
+	FormatPlain    Format = "plain"
+	FormatMarkdown Format = "markdown"
+	FormatXML      Format = "xml"
+	FormatJSON     Format = "json"
+)
+
+// ValidFormats は -format フラグが取りうる値です。
+var ValidFormats = []Format{FormatPlain, FormatMarkdown, FormatXML, FormatJSON}
+
+// NewWriter は format に応じた Writer を構築します。
+func NewWriter(format Format, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatPlain:
+		return &plainWriter{w: w}, nil
+	case FormatMarkdown:
+		return &markdownWriter{w: w}, nil
+	case FormatXML:
+		return &xmlWriter{w: w, enc: xml.NewEncoder(w)}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("サポートされていない出力形式です: %s", format)
+	}
+}
+
+// plainWriter は既存の素朴なテキスト形式です。
+type plainWriter struct {
+	w io.Writer
+}
+
+func (pw *plainWriter) WriteHeader() error {
+	_, err := io.WriteString(pw.w, "以下は対象リポジトリのすべてのファイル内容です。\nこれらを参考に、後述の指示に従ってリポジトリを変更してください。\n\n")
+	return err
+}
+
+func (pw *plainWriter) WriteFile(f File) error {
+	_, err := fmt.Fprintf(pw.w, "----------\n[File]: %s\n[Content Start]\n%s\n[Content End]\n\n", f.Path, f.Content)
+	return err
+}
+
+func (pw *plainWriter) WriteInstructions(instructions string) error {
+	_, err := fmt.Fprintf(pw.w, "----------\n以下が指示文です:\n%s", instructions)
+	return err
+}
+
+// languageByExtension はファイル拡張子から Markdown のコードフェンスに使う言語名を引きます。
+var languageByExtension = map[string]string{
+	".go":   "go",
+	".py":   "python",
+	".js":   "javascript",
+	".jsx":  "jsx",
+	".ts":   "typescript",
+	".tsx":  "tsx",
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".sh":   "bash",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".java": "java",
+	".c":    "c",
+	".h":    "c",
+	".cpp":  "cpp",
+	".md":   "markdown",
+	".html": "html",
+	".css":  "css",
+	".sql":  "sql",
+}
+
+// markdownWriter は各ファイルをコードフェンスで囲んだ Markdown 形式で出力します。
+type markdownWriter struct {
+	w io.Writer
+}
+
+func (pw *markdownWriter) WriteHeader() error {
+	_, err := io.WriteString(pw.w, "# 対象リポジトリのファイル一覧\n\n以下を参考に、後述の指示に従ってリポジトリを変更してください。\n\n")
+	return err
+}
+
+// fenceFor は f.Content に含まれる最長のバッククォート連続よりも長いフェンスを返します。
+// 固定で3つのバッククォートを使うと、ファイル自身が ``` を含む場合（README や
+// ヒアドキュメントを含むシェルスクリプトなど）に外側のフェンスが途中で閉じてしまうため、
+// CommonMark の作法に従いフェンスの長さを内容に応じて決めます。
+func fenceFor(content string) string {
+	longest, current := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			current++
+			if current > longest {
+				longest = current
+			}
+		} else {
+			current = 0
+		}
+	}
+	length := longest + 1
+	if length < 3 {
+		length = 3
+	}
+	return strings.Repeat("`", length)
+}
+
+func (pw *markdownWriter) WriteFile(f File) error {
+	lang := languageByExtension[strings.ToLower(filepath.Ext(f.Path))]
+	fence := fenceFor(f.Content)
+	_, err := fmt.Fprintf(pw.w, "### %s\n\n%s%s\n%s\n%s\n\n", f.Path, fence, lang, f.Content, fence)
+	return err
+}
+
+func (pw *markdownWriter) WriteInstructions(instructions string) error {
+	_, err := fmt.Fprintf(pw.w, "## 指示文\n\n%s", instructions)
+	return err
+}
+
+// xmlFile は Anthropic 形式の `<file path="...">...</file>` を表します。
+type xmlFile struct {
+	XMLName xml.Name `xml:"file"`
+	Path    string   `xml:"path,attr"`
+	Content string   `xml:",chardata"`
+}
+
+// xmlInstructions は `<documents>` ルート配下に置く `<instructions>` 要素を表します。
+// `<file>` と同じく xml.Encoder 経由で書き出すことで、`<`/`&`/`"` などを正しくエスケープします。
+type xmlInstructions struct {
+	XMLName xml.Name `xml:"instructions"`
+	Content string   `xml:",chardata"`
+}
+
+// xmlWriter は `<documents>` ルート配下に各ファイルを `<file path="...">`、続けて
+// `<instructions>` を出力します。指示文も同じ `<documents>` ルートの中に収め、
+// stdout 全体が単一の well-formed な XML ドキュメントになるようにしています。
+type xmlWriter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func (pw *xmlWriter) WriteHeader() error {
+	_, err := io.WriteString(pw.w, "<documents>\n")
+	return err
+}
+
+func (pw *xmlWriter) WriteFile(f File) error {
+	if err := pw.enc.Encode(xmlFile{Path: f.Path, Content: f.Content}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(pw.w, "\n")
+	return err
+}
+
+func (pw *xmlWriter) WriteInstructions(instructions string) error {
+	if err := pw.enc.Encode(xmlInstructions{Content: instructions}); err != nil {
+		return err
+	}
+	if err := pw.enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(pw.w, "\n</documents>\n")
+	return err
+}
+
+// jsonFile は -format json における 1 ファイル分の JSON 表現です。
+type jsonFile struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+// jsonWriter は `{"files":[...], "instructions": "..."}` をファイルごとに逐次書き出します。
+type jsonWriter struct {
+	w     io.Writer
+	wrote bool
+}
+
+func (pw *jsonWriter) WriteHeader() error {
+	_, err := io.WriteString(pw.w, `{"files":[`)
+	return err
+}
+
+func (pw *jsonWriter) WriteFile(f File) error {
+	if pw.wrote {
+		if _, err := io.WriteString(pw.w, ","); err != nil {
+			return err
+		}
+	}
+	pw.wrote = true
+
+	data, err := json.Marshal(jsonFile{Path: f.Path, Content: f.Content, Encoding: f.DetectedEncoding})
+	if err != nil {
+		return err
+	}
+	_, err = pw.w.Write(data)
+	return err
+}
+
+func (pw *jsonWriter) WriteInstructions(instructions string) error {
+	data, err := json.Marshal(instructions)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(pw.w, `],"instructions":%s}`, data)
+	return err
+}
@@ -0,0 +1,123 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func writeRoundTrip(t *testing.T, format Format, f File, instructions string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter(%s) error: %v", format, err)
+	}
+	if err := w.WriteHeader(); err != nil {
+		t.Fatalf("WriteHeader: %v", err)
+	}
+	if err := w.WriteFile(f); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := w.WriteInstructions(instructions); err != nil {
+		t.Fatalf("WriteInstructions: %v", err)
+	}
+	return buf.String()
+}
+
+func TestPlainWriterRoundTrip(t *testing.T) {
+	f := File{Path: "main.go", Content: "package main\n"}
+	out := writeRoundTrip(t, FormatPlain, f, "テスト指示")
+	if !strings.Contains(out, f.Path) || !strings.Contains(out, f.Content) || !strings.Contains(out, "テスト指示") {
+		t.Errorf("plain output missing expected content: %q", out)
+	}
+}
+
+func TestMarkdownWriterRoundTrip(t *testing.T) {
+	f := File{Path: "main.go", Content: "package main\n"}
+	out := writeRoundTrip(t, FormatMarkdown, f, "テスト指示")
+	if !strings.Contains(out, "```go") || !strings.Contains(out, f.Content) || !strings.Contains(out, "テスト指示") {
+		t.Errorf("markdown output missing expected content: %q", out)
+	}
+}
+
+func TestMarkdownWriterWidensFenceForNestedBackticks(t *testing.T) {
+	content := "before\n```go\nfmt.Println(\"hi\")\n```\nafter\n"
+	f := File{Path: "README.md", Content: content}
+	out := writeRoundTrip(t, FormatMarkdown, f, "")
+
+	if strings.Contains(out, "after\n```\n\n## 指示文") {
+		t.Fatalf("outer fence closed early, leaving trailing content unfenced: %q", out)
+	}
+	if !strings.Contains(out, "````") {
+		t.Errorf("expected a 4-backtick outer fence, got: %q", out)
+	}
+}
+
+type xmlDocuments struct {
+	XMLName xml.Name `xml:"documents"`
+	Files   []struct {
+		Path    string `xml:"path,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"file"`
+	Instructions string `xml:"instructions"`
+}
+
+func TestXMLWriterRoundTrip(t *testing.T) {
+	f := File{Path: "main.go", Content: "package main\n"}
+	out := writeRoundTrip(t, FormatXML, f, "テスト指示")
+
+	var doc xmlDocuments
+	if err := xml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("failed to parse xml output: %v\n%s", err, out)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].Path != f.Path || doc.Files[0].Content != f.Content {
+		t.Errorf("unexpected parsed files: %+v", doc.Files)
+	}
+	if doc.Instructions != "テスト指示" {
+		t.Errorf("unexpected instructions: %q", doc.Instructions)
+	}
+}
+
+func TestXMLWriterEscapesInstructions(t *testing.T) {
+	f := File{Path: "main.go", Content: "package main\n"}
+	instructions := `rename <Button> to <Input> and fix a && b, then say "done"`
+	out := writeRoundTrip(t, FormatXML, f, instructions)
+
+	if strings.Contains(out, "<Button>") || strings.Contains(out, "a && b") {
+		t.Fatalf("instructions were not escaped, output is not well-formed xml: %q", out)
+	}
+
+	var doc xmlDocuments
+	if err := xml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output is not a single well-formed xml document: %v\n%s", err, out)
+	}
+	if doc.Instructions != instructions {
+		t.Errorf("instructions did not round-trip: got %q, want %q", doc.Instructions, instructions)
+	}
+}
+
+func TestJSONWriterRoundTrip(t *testing.T) {
+	f := File{Path: "main.go", Content: "package main\n", DetectedEncoding: "utf-8"}
+	out := writeRoundTrip(t, FormatJSON, f, "テスト指示")
+
+	var doc struct {
+		Files []struct {
+			Path     string `json:"path"`
+			Content  string `json:"content"`
+			Encoding string `json:"encoding"`
+		} `json:"files"`
+		Instructions string `json:"instructions"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("failed to parse json output: %v\n%s", err, out)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].Path != f.Path || doc.Files[0].Content != f.Content || doc.Files[0].Encoding != f.DetectedEncoding {
+		t.Errorf("unexpected parsed files: %+v", doc.Files)
+	}
+	if doc.Instructions != "テスト指示" {
+		t.Errorf("unexpected instructions: %q", doc.Instructions)
+	}
+}
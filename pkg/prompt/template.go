@@ -0,0 +1,25 @@
+package prompt
+
+import (
+	"io"
+	"os"
+	"text/template"
+)
+
+// RenderTemplate は tmplPath にあるユーザー定義の text/template を読み込み、
+// doc（.Files と .Instructions、各ファイルの .Path/.Content/.DetectedEncoding）を渡して w に書き出します。
+// -template はファイル全体を保持してからレンダリングするため、他の形式と異なりメモリ使用量は
+// リポジトリ全体のサイズに比例します。
+func RenderTemplate(w io.Writer, tmplPath string, doc Document) error {
+	data, err := os.ReadFile(tmplPath)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(tmplPath).Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, doc)
+}